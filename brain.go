@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Brain holds one Chain per (network, channel) partition so that rooms
+// with unrelated conversations don't get mixed into a single model.
+type Brain struct {
+	mu     sync.RWMutex
+	Chains map[string]*Chain
+}
+
+// NewBrain returns an empty Brain.
+func NewBrain() *Brain {
+	return &Brain{Chains: make(map[string]*Chain)}
+}
+
+// partitionKey identifies a Brain partition by network and channel.
+func partitionKey(network, origin string) string {
+	return network + "/" + strings.ToLower(origin)
+}
+
+// Get returns the Chain for (network, origin), creating an empty one on
+// first use.
+func (b *Brain) Get(network, origin string) *Chain {
+	key := partitionKey(network, origin)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.Chains[key]
+	if !ok {
+		ch = NewChain(*prefixLen)
+		b.Chains[key] = ch
+	}
+	return ch
+}
+
+// Peek returns the Chain for (network, origin) without creating one,
+// for callers that must not let client-supplied input grow Chains
+// without bound (e.g. the read-only HTTP admin endpoints).
+func (b *Brain) Peek(network, origin string) (*Chain, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ch, ok := b.Chains[partitionKey(network, origin)]
+	return ch, ok
+}
+
+// List returns the key ("network/origin") of every partition, sorted for
+// stable output.
+func (b *Brain) List() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	origins := make([]string, 0, len(b.Chains))
+	for key := range b.Chains {
+		origins = append(origins, key)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
+// Borrow makes dst share src's Chain so dst can reply with src's corpus
+// until Reset is called on it. It reports whether src has a partition.
+func (b *Brain) Borrow(network, dst, src string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.Chains[partitionKey(network, src)]
+	if !ok {
+		return false
+	}
+	b.Chains[partitionKey(network, dst)] = ch
+	return true
+}
+
+// Reset replaces origin's Chain with an empty one.
+func (b *Brain) Reset(network, origin string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Chains[partitionKey(network, origin)] = NewChain(*prefixLen)
+}
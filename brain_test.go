@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestBorrowSharesChainButKeepsPerCallTagging guards against the failure
+// mode Borrow used to invite: since dst and src end up pointing at the
+// same *Chain, tagging had to live on the Chain itself, so concurrent
+// traffic on both origins raced to stomp it and journal entries could be
+// misfiled to the wrong partition on replay. Tagging now comes from the
+// caller (see learn's use of BuildWithObserver), not the shared Chain, so
+// concurrent callers on both origins must each see their own tag.
+func TestBorrowSharesChainButKeepsPerCallTagging(t *testing.T) {
+	b := NewBrain()
+	b.Get("net", "src").Build(strings.NewReader("alpha beta"))
+	if !b.Borrow("net", "dst", "src") {
+		t.Fatal("expected Borrow to succeed")
+	}
+	if b.Get("net", "dst") != b.Get("net", "src") {
+		t.Fatal("expected dst and src to share the same *Chain after Borrow")
+	}
+
+	var mu sync.Mutex
+	tags := map[string]int{}
+	var wg sync.WaitGroup
+	for _, origin := range []string{"src", "dst"} {
+		origin := origin
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch := b.Get("net", origin)
+			ch.BuildWithObserver(strings.NewReader("gamma delta"), func(prefix, suffix string) {
+				mu.Lock()
+				tags[origin]++
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+
+	if tags["src"] == 0 || tags["dst"] == 0 {
+		t.Fatalf("expected both callers' observers to fire under their own origin, got %v", tags)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// numShards is the number of stripes a Chain's data is split across.
+// Build and Generate only ever contend on the single shard their prefix
+// hashes to, instead of one lock for the whole chain.
+const numShards = 16
+
+// Prefix is a Markov chain prefix of one or more words.
+type Prefix []string
+
+// String returns the Prefix as a string (for use as a map key).
+func (p Prefix) String() string {
+	return strings.Join(p, " ")
+}
+
+// Shift removes the first word from the Prefix and appends the given word.
+func (p Prefix) Shift(word string) {
+	copy(p, p[1:])
+	p[len(p)-1] = word
+}
+
+// chainShard holds one stripe of a Chain's prefix/suffix data behind its
+// own lock.
+type chainShard struct {
+	mu       sync.RWMutex
+	mapChain map[string][]string
+	freq     map[string]map[string]int
+}
+
+// Chain contains a sharded map ("chain") of prefixes to a list of
+// suffixes. A prefix is a string of PrefixLen words joined with spaces.
+// A suffix is a single word. A prefix can have multiple suffixes.
+//
+// Each shard's freq mirrors its mapChain but records how many times each
+// suffix was observed for a given prefix, so Generate can weight its
+// pick instead of treating every recorded occurrence as a separate slot.
+//
+// A Chain has no notion of which Brain partition it belongs to: two
+// partitions can share the same *Chain (see Brain.Borrow), so that
+// identity can't live on the Chain itself without racing. Callers that
+// need to journal what they Build pass that context in per call instead
+// (see BuildWithObserver).
+type Chain struct {
+	PrefixLen int
+	shards    []*chainShard
+}
+
+// NewChain returns a new Chain with prefixes of prefixLen words.
+func NewChain(prefixLen int) *Chain {
+	shards := make([]*chainShard, numShards)
+	for i := range shards {
+		shards[i] = &chainShard{mapChain: make(map[string][]string), freq: make(map[string]map[string]int)}
+	}
+	return &Chain{PrefixLen: prefixLen, shards: shards}
+}
+
+// shardFor returns the shard that owns key.
+func (c *Chain) shardFor(key string) *chainShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// record adds a single {key, suffix} observation to c, used by both
+// Build and journal replay.
+func (c *Chain) record(key, suffix string) {
+	sh := c.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.mapChain[key] = append(sh.mapChain[key], suffix)
+	if sh.freq[key] == nil {
+		sh.freq[key] = make(map[string]int)
+	}
+	sh.freq[key][suffix]++
+}
+
+// Build reads text from the provided Reader and, via tokenizer, parses
+// it into prefixes and suffixes that are stored in Chain.
+func (c *Chain) Build(r io.Reader) bool {
+	return c.BuildWithObserver(r, nil)
+}
+
+// TokenObserver is called once per {prefix, suffix} tuple as
+// BuildWithObserver records it, so a caller can journal (or otherwise
+// react to) exactly what was learned without the Chain itself needing to
+// know which Brain partition it belongs to.
+type TokenObserver func(prefix, suffix string)
+
+// BuildWithObserver is Build, plus a callback invoked for every tuple
+// recorded. learn() uses this to journal tuples under the origin that is
+// actually receiving the traffic, which stays correct even when that
+// origin's Chain is shared with another partition via Brain.Borrow.
+func (c *Chain) BuildWithObserver(r io.Reader, observe TokenObserver) bool {
+	br := bufio.NewReader(r)
+	p := make(Prefix, c.PrefixLen)
+	for {
+		var raw string
+		if _, err := fmt.Fscan(br, &raw); err != nil {
+			break
+		}
+		for _, s := range tokenizer.TokenizeWord(raw) {
+			key := p.String()
+			c.record(key, s)
+			if observe != nil {
+				observe(key, s)
+			}
+			p.Shift(s)
+		}
+	}
+	return true
+}
+
+// weightedSuffix picks a suffix for key with probability proportional to
+// how often it was observed, biased by *novelty: 1.0 is plain
+// frequency-proportional selection, values above 1 flatten the
+// distribution towards rarer suffixes.
+func weightedSuffix(counts map[string]int) (string, bool) {
+	if len(counts) == 0 {
+		return "", false
+	}
+	temp := *novelty
+	if temp <= 0 {
+		temp = 1.0
+	}
+	total := 0.0
+	weights := make(map[string]float64, len(counts))
+	for suffix, n := range counts {
+		w := math.Pow(float64(n), 1.0/temp)
+		weights[suffix] = w
+		total += w
+	}
+	r := rand.Float64() * total
+	for suffix, w := range weights {
+		r -= w
+		if r <= 0 {
+			return suffix, true
+		}
+	}
+	// Fell through due to floating point rounding; return any suffix.
+	for suffix := range counts {
+		return suffix, true
+	}
+	return "", false
+}
+
+// nextSuffix returns the next word for prefix key, preferring the
+// weighted freq table and falling back to a uniform pick over mapChain.
+func (c *Chain) nextSuffix(key string) (string, bool) {
+	sh := c.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if counts, ok := sh.freq[key]; ok {
+		return weightedSuffix(counts)
+	}
+	choices := sh.mapChain[key]
+	if len(choices) == 0 {
+		return "", false
+	}
+	return choices[rand.Intn(len(choices))], true
+}
+
+// Generate returns a string of at most n words generated from Chain,
+// starting from an empty prefix.
+func (c *Chain) Generate(n int) string {
+	return c.generateFrom(make(Prefix, c.PrefixLen), n)
+}
+
+// GenerateFrom returns a string of at most n words generated from Chain,
+// primed with seed. It first looks for a known prefix matching the last
+// PrefixLen words of seed; if that fails, it falls back to a random
+// known prefix that contains any word from seed; if even that fails, it
+// behaves like Generate.
+func (c *Chain) GenerateFrom(seed string, n int) string {
+	return c.generateFrom(c.seedPrefix(seed), n)
+}
+
+// seedPrefix resolves a seed string to a starting Prefix for generation.
+func (c *Chain) seedPrefix(seed string) Prefix {
+	p := make(Prefix, c.PrefixLen)
+	words := strings.Fields(strings.ToLower(seed))
+	if len(words) == 0 {
+		return p
+	}
+	if len(words) >= c.PrefixLen {
+		candidate := Prefix(words[len(words)-c.PrefixLen:])
+		if c.knownPrefix(candidate.String()) {
+			copy(p, candidate)
+			return p
+		}
+	}
+	var matches []string
+	for _, sh := range c.shards {
+		sh.mu.RLock()
+		for key := range sh.mapChain {
+			for _, w := range words {
+				if w != "" && strings.Contains(key, w) {
+					matches = append(matches, key)
+					break
+				}
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	if len(matches) > 0 {
+		copy(p, strings.Split(matches[rand.Intn(len(matches))], " "))
+	}
+	return p
+}
+
+// knownPrefix reports whether key has any recorded suffixes.
+func (c *Chain) knownPrefix(key string) bool {
+	sh := c.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	_, ok := sh.mapChain[key]
+	return ok
+}
+
+// generateFrom runs the actual generation loop starting from prefix p,
+// stopping after n tokens or, if *stopAtSentence is set, after the
+// first sentence-terminating token (whichever comes first).
+func (c *Chain) generateFrom(p Prefix, n int) string {
+	var tokens []string
+	for i := 0; i < n; i++ {
+		next, ok := c.nextSuffix(p.String())
+		if !ok {
+			break
+		}
+		tokens = append(tokens, next)
+		if *stopAtSentence && sentenceEnders[next] {
+			break
+		}
+		p.Shift(next)
+	}
+	return detokenizer.Detokenize(tokens)
+}
+
+// PrefixStat describes one prefix's fan-out, for Stats' top-N listing.
+type PrefixStat struct {
+	Prefix string `json:"prefix"`
+	Fanout int    `json:"fanout"`
+}
+
+// ChainStats is a read-only snapshot of a Chain's size, used by the
+// /stats HTTP endpoint.
+type ChainStats struct {
+	Prefixes    int          `json:"prefixes"`
+	Suffixes    int          `json:"suffixes"`
+	TopPrefixes []PrefixStat `json:"top_prefixes"`
+	ApproxBytes int64        `json:"approx_bytes"`
+}
+
+// Stats walks every shard and summarizes Chain size: how many prefixes
+// and suffix occurrences it holds, its topN prefixes by fan-out, and a
+// rough byte-size estimate (just the prefix/suffix string lengths, not
+// Go's map/slice overhead).
+func (c *Chain) Stats(topN int) ChainStats {
+	var stats ChainStats
+	var all []PrefixStat
+	for _, sh := range c.shards {
+		sh.mu.RLock()
+		for k, v := range sh.mapChain {
+			stats.Prefixes++
+			stats.Suffixes += len(v)
+			all = append(all, PrefixStat{Prefix: k, Fanout: len(v)})
+			stats.ApproxBytes += int64(len(k))
+			for _, s := range v {
+				stats.ApproxBytes += int64(len(s))
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Fanout > all[j].Fanout })
+	if topN > len(all) {
+		topN = len(all)
+	}
+	stats.TopPrefixes = all[:topN]
+	return stats
+}
+
+// SuffixFrequencies returns a copy of the observed suffix counts for
+// prefix, for the /dump HTTP endpoint.
+func (c *Chain) SuffixFrequencies(prefix string) map[string]int {
+	sh := c.shardFor(prefix)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	out := make(map[string]int, len(sh.freq[prefix]))
+	for suffix, n := range sh.freq[prefix] {
+		out[suffix] = n
+	}
+	return out
+}
+
+// chainGobData is the flat, unsharded representation a Chain is
+// serialized as, so the on-disk format doesn't depend on numShards.
+type chainGobData struct {
+	MapChain    map[string][]string
+	Frequencies map[string]map[string]int
+	PrefixLen   int
+}
+
+// GobEncode flattens all shards into a single snapshot.
+func (c *Chain) GobEncode() ([]byte, error) {
+	data := chainGobData{
+		MapChain:    make(map[string][]string),
+		Frequencies: make(map[string]map[string]int),
+		PrefixLen:   c.PrefixLen,
+	}
+	for _, sh := range c.shards {
+		sh.mu.RLock()
+		for k, v := range sh.mapChain {
+			data.MapChain[k] = v
+		}
+		for k, v := range sh.freq {
+			data.Frequencies[k] = v
+		}
+		sh.mu.RUnlock()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode rebuilds a sharded Chain from a flat snapshot.
+func (c *Chain) GobDecode(b []byte) error {
+	var data chainGobData
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		return err
+	}
+	*c = *NewChain(data.PrefixLen)
+	for k, v := range data.MapChain {
+		sh := c.shardFor(k)
+		sh.mapChain[k] = v
+	}
+	for k, v := range data.Frequencies {
+		sh := c.shardFor(k)
+		sh.freq[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// serveHTTP runs the optional admin/stats listener on addr. It lets an
+// operator inspect and feed the brain without being on IRC: /stats,
+// /generate and /dump only read, while /train (POST) queues text for
+// learning.
+func serveHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", handleStats)
+	mux.HandleFunc("/generate", handleGenerate)
+	mux.HandleFunc("/dump", handleDump)
+	mux.HandleFunc("/train", handleTrain)
+	log.Printf("Serving brain admin endpoints on %s\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("HTTP admin listener stopped: %s\n", err)
+	}
+}
+
+// httpOrigin picks the Brain partition an admin request operates on: the
+// ?channel= query param if given, else the first -channel joined, else
+// a dedicated "http" partition.
+func httpOrigin(r *http.Request) string {
+	if origin := r.URL.Query().Get("channel"); origin != "" {
+		return origin
+	}
+	if chans := channelList(); len(chans) > 0 {
+		return chans[0]
+	}
+	return "http"
+}
+
+// wantsJSON reports whether the client asked for JSON via Accept or
+// ?format=json, falling back to plaintext otherwise.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "json")
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	var stats ChainStats
+	if ch, ok := brain.Peek(*server, httpOrigin(r)); ok {
+		stats = ch.Stats(10)
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	fmt.Fprintf(w, "prefixes: %d\nsuffixes: %d\napprox bytes: %d\ntop prefixes:\n", stats.Prefixes, stats.Suffixes, stats.ApproxBytes)
+	for _, p := range stats.TopPrefixes {
+		fmt.Fprintf(w, "  %-30s %d\n", p.Prefix, p.Fanout)
+	}
+}
+
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	n := *numWords
+	if v := r.URL.Query().Get("n"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			n = parsed
+		}
+	}
+	seed := r.URL.Query().Get("seed")
+	var reply string
+	if ch, ok := brain.Peek(*server, httpOrigin(r)); ok {
+		if seed == "" {
+			reply = ch.Generate(n)
+		} else {
+			reply = ch.GenerateFrom(seed, n)
+		}
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"text": reply})
+		return
+	}
+	fmt.Fprintln(w, reply)
+}
+
+func handleDump(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		http.Error(w, "missing prefix parameter", http.StatusBadRequest)
+		return
+	}
+	freq := map[string]int{}
+	if ch, ok := brain.Peek(*server, httpOrigin(r)); ok {
+		freq = ch.SuffixFrequencies(prefix)
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(freq)
+		return
+	}
+	for suffix, n := range freq {
+		fmt.Fprintf(w, "%s: %d\n", suffix, n)
+	}
+}
+
+func handleTrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+	learnChannel <- learnMsg{Origin: httpOrigin(r), Text: string(body)}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "queued for training")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// maxIRCLine is the maximum size, in bytes, of a raw IRC protocol line
+// including its trailing \r\n, as specified by RFC 2812.
+const maxIRCLine = 512
+
+// sendSplit sends text to target as one or more PRIVMSGs, splitting on
+// word boundaries so that no line (once the server re-prepends the
+// sender's hostmask) exceeds maxIRCLine. Without this, a long Generate
+// result gets silently truncated by the ircd instead of wrapped.
+func sendSplit(conn *irc.Conn, target, text string) {
+	for _, line := range wrapReply(conn, target, text) {
+		conn.Privmsg(target, line)
+	}
+}
+
+// wrapReply splits text into chunks that fit lineBudget for (conn, target).
+func wrapReply(conn *irc.Conn, target, text string) []string {
+	return wrapWords(text, lineBudget(conn, target))
+}
+
+// lineBudget computes how many bytes of message text fit in a PRIVMSG
+// to target, given the hostmask the server will prepend to whatever we
+// send: ":nick!ident@host PRIVMSG target :" followed by "\r\n".
+func lineBudget(conn *irc.Conn, target string) int {
+	me := conn.Me()
+	overhead := len(fmt.Sprintf(":%s!%s@%s PRIVMSG %s :", me.Nick, me.Ident, me.Host, target)) + len("\r\n")
+	budget := maxIRCLine - overhead
+	if budget < 1 {
+		budget = 1
+	}
+	return budget
+}
+
+// wrapWords greedily packs words into lines no longer than budget bytes.
+// A single word longer than budget is itself chopped on UTF-8 rune
+// boundaries rather than overflowing the line; only when budget is
+// smaller than the word's next rune is that rune cut at budget bytes.
+func wrapWords(text string, budget int) []string {
+	if budget < 1 {
+		budget = 1
+	}
+	var lines []string
+	cur := ""
+	for _, w := range strings.Fields(text) {
+		candidate := w
+		if cur != "" {
+			candidate = cur + " " + w
+		}
+		if len(candidate) <= budget {
+			cur = candidate
+			continue
+		}
+		if cur != "" {
+			lines = append(lines, cur)
+			cur = ""
+		}
+		for len(w) > budget {
+			cut := budget
+			for cut > 0 && !utf8.RuneStart(w[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = budget
+			}
+			lines = append(lines, w[:cut])
+			w = w[cut:]
+		}
+		cur = w
+	}
+	if cur != "" {
+		lines = append(lines, cur)
+	}
+	return lines
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWrapWordsPacksWithinBudget(t *testing.T) {
+	lines := wrapWords("the quick brown fox jumps", 10)
+	for _, l := range lines {
+		if len(l) > 10 {
+			t.Errorf("line %q exceeds budget of 10 bytes", l)
+		}
+	}
+	if strings.Join(lines, " ") != "the quick brown fox jumps" {
+		t.Errorf("wrapWords lost or reordered words: %v", lines)
+	}
+}
+
+// TestWrapWordsRuneBoundary guards the UTF-8 chopping path: a single word
+// longer than budget must be cut on rune boundaries, never splitting a
+// multi-byte rune across two lines.
+func TestWrapWordsRuneBoundary(t *testing.T) {
+	word := strings.Repeat("日本語", 5) // 3-byte runes, 45 bytes total
+	lines := wrapWords(word, 10)
+
+	var rebuilt strings.Builder
+	for _, l := range lines {
+		if len(l) > 10 {
+			t.Errorf("chunk %q exceeds budget of 10 bytes", l)
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("chunk %q is not valid UTF-8 (split mid-rune)", l)
+		}
+		rebuilt.WriteString(l)
+	}
+	if rebuilt.String() != word {
+		t.Errorf("wrapWords(%q, 10) chunks joined to %q, want %q", word, rebuilt.String(), word)
+	}
+}
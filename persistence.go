@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// compactionInterval controls how often the in-memory Chain is snapshotted
+// to brainFile and the journal is truncated.
+const compactionInterval = 10 * time.Second
+
+// journalEntry is a single {prefix, suffix} tuple as recorded in the
+// append-only journal, tagged with the Brain partition it belongs to.
+type journalEntry struct {
+	Network string
+	Origin  string
+	Prefix  string
+	Suffix  string
+}
+
+// Journal is an append-only log of journalEntry records, each written as
+// a 4-byte big-endian length prefix followed by its gob encoding. It lets
+// learnChannel input survive a crash between snapshot compactions without
+// holding the chain mutex for the cost of a full gob.Encode.
+type Journal struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	offset int64 // bytes appended so far, for TruncateBefore
+}
+
+// OpenJournal opens (or creates) the journal file at path for appending.
+// It's opened after replayJournal has already read whatever the file held
+// from a previous run, so offset starts at the file's current size rather
+// than 0.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Journal{f: f, w: bufio.NewWriter(f), offset: info.Size()}, nil
+}
+
+// Append buffers a {prefix, suffix} tuple for writing. It does not fsync;
+// call Flush once the caller's batch of appends is complete.
+func (j *Journal) Append(network, origin, prefix, suffix string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(journalEntry{network, origin, prefix, suffix}); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(buf.Len()))
+	if _, err := j.w.Write(lenPrefix[:]); err != nil {
+		return err
+	}
+	if _, err := j.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	j.offset += int64(len(lenPrefix)) + int64(buf.Len())
+	return nil
+}
+
+// Offset returns the current end-of-journal byte offset, i.e. how many
+// bytes of journalEntry records have been appended (buffered or not) so
+// far. compact captures this before it starts snapshotting the Brain, so
+// TruncateBefore only discards what the snapshot actually reflects.
+func (j *Journal) Offset() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.offset
+}
+
+// Flush drains the buffered writer and fsyncs the journal file, making
+// every Append since the last Flush durable.
+func (j *Journal) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// TruncateBefore discards every journal record before byte offset,
+// preserving anything appended at or after it. Callers must only pass an
+// offset whose preceding records are reflected in a durable snapshot
+// elsewhere; records appended while that snapshot was being taken land
+// at or after offset and are kept, rather than silently dropped.
+func (j *Journal) TruncateBefore(offset int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := j.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tail, err := io.ReadAll(j.f)
+	if err != nil {
+		return err
+	}
+	if err := j.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := j.f.Write(tail); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.w = bufio.NewWriter(j.f)
+	j.offset = int64(len(tail))
+	return nil
+}
+
+// replayJournal reads every journalEntry in path and re-applies it to the
+// matching partition of b. A missing journal file is not an error
+// (nothing to replay yet); a truncated trailing record, which can happen
+// if the process died mid-write, is treated as the end of the journal
+// rather than an error.
+func replayJournal(path string, b *Brain) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	replayed := 0
+	for {
+		var lenPrefix [4]byte
+		if _, err := io.ReadFull(br, lenPrefix[:]); err != nil {
+			break
+		}
+		data := make([]byte, binary.BigEndian.Uint32(lenPrefix[:]))
+		if _, err := io.ReadFull(br, data); err != nil {
+			break
+		}
+		var entry journalEntry
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+			break
+		}
+		c := b.Get(entry.Network, entry.Origin)
+		c.record(entry.Prefix, entry.Suffix)
+		replayed++
+	}
+	if replayed > 0 {
+		log.Printf("Replayed %d journal entries\n", replayed)
+	}
+	return nil
+}
+
+// compactLoop periodically snapshots b to brainPath and truncates the
+// journal j, forever (or until the process exits).
+func compactLoop(brainPath string, b *Brain, j *Journal) {
+	for {
+		time.Sleep(compactionInterval)
+		if err := compact(brainPath, b, j); err != nil {
+			log.Printf("Could not compact brain: %s\n", err)
+		}
+	}
+}
+
+// compact writes a full snapshot of b to a temp file, atomically renames
+// it over brainPath, then truncates the journal of everything the
+// snapshot covers.
+//
+// It captures the journal's offset before it starts encoding, not after:
+// record (chain.go) always completes before the Append it feeds lands in
+// the journal, so anything already appended at the captured offset is
+// guaranteed to already be reflected in the Brain state RLock sees below.
+// A Build racing with the encode either lands its record before RLock
+// (and so is captured in the snapshot, with its Append simply preserved
+// as a harmless duplicate on replay) or after (and its Append is at or
+// past offset, so it's kept rather than truncated away). Either way,
+// nothing journaled is ever discarded before it's durable somewhere.
+func compact(brainPath string, b *Brain, j *Journal) error {
+	offset := j.Offset()
+
+	tmpPath := brainPath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	encErr := gob.NewEncoder(tmp).Encode(b)
+	b.mu.RUnlock()
+	if encErr != nil {
+		tmp.Close()
+		return encErr
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, brainPath); err != nil {
+		return err
+	}
+	log.Println("Compacted brain to disk")
+	return j.TruncateBefore(offset)
+}
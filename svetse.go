@@ -1,113 +1,77 @@
 package main
 
 import (
-	"bufio"
 	"encoding/gob"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	irc "github.com/fluffle/goirc/client"
 )
 
-var c *Chain
+var brain *Brain
 var numWords *int
 var prefixLen *int
+var novelty *float64
+var stopAtSentence *bool
 var server *string
 var channel *string
+var httpAddr *string
 
 var myNick *string
 
-var mutex *sync.Mutex
+// journal backs learn with crash-safe incremental persistence; see
+// persistence.go. It is nil until main opens it, and learn skips
+// journaling while it is nil.
+var journal *Journal
+
+// learnMsg and replyMsg tag traffic with the origin channel (or, for a
+// private message, the sender's nick) so it's routed to the right Brain
+// partition instead of a single global Chain.
+type learnMsg struct {
+	Origin string
+	Text   string
+}
+
+type replyMsg struct {
+	Origin string
+	Seed   string
+	Text   string
+}
 
-var replyChannel chan string
-var learnChannel chan string
+var replyChannel chan replyMsg
+var learnChannel chan learnMsg
 
 func init() {
 	rand.Seed(time.Now().UnixNano()) // Seed the random number generator.
 	numWords = flag.Int("words", 100, "maximum number of words to print")
 	prefixLen = flag.Int("prefix", 2, "prefix length in words")
+	novelty = flag.Float64("novelty", 1.0, "suffix selection temperature; >1 favors rarer/novel suffixes, 1 is frequency-proportional")
+	stopAtSentence = flag.Bool("sentence-stop", true, "stop generation at the first sentence-terminating token (. ! ?)")
 	server = flag.String("server", "irc.efnet.org", "server to connect to (irc.something.net:6667)")
-	channel = flag.String("channel", "#chatbotpurgatory", "channel to join")
+	channel = flag.String("channel", "#chatbotpurgatory", "comma-separated list of channels to join")
 	myNick = flag.String("nickname", "SVETSE", "nickname for the bot")
+	httpAddr = flag.String("http", "", "address for an optional brain admin/stats HTTP listener (e.g. :8080); empty disables it")
 
-	mutex = &sync.Mutex{}
-
-	flag.Parse() // Parse command-line flags.
-
-	replyChannel = make(chan string)
-	learnChannel = make(chan string)
-}
-
-// Prefix is a Markov chain prefix of one or more words.
-type Prefix []string
-
-// String returns the Prefix as a string (for use as a map key).
-func (p Prefix) String() string {
-	return strings.Join(p, " ")
-}
-
-// Shift removes the first word from the Prefix and appends the given word.
-func (p Prefix) Shift(word string) {
-	copy(p, p[1:])
-	p[len(p)-1] = word
-}
-
-// Chain contains a map ("chain") of prefixes to a list of suffixes.
-// A prefix is a string of prefixLen words joined with spaces.
-// A suffix is a single word. A prefix can have multiple suffixes.
-type Chain struct {
-	MapChain  map[string][]string
-	PrefixLen int
-}
-
-// NewChain returns a new Chain with prefixes of prefixLen words.
-func NewChain(prefixLen int) *Chain {
-	return &Chain{make(map[string][]string), prefixLen}
+	replyChannel = make(chan replyMsg)
+	learnChannel = make(chan learnMsg)
 }
 
-// Build reads text from the provided Reader and
-// parses it into prefixes and suffixes that are stored in Chain.
-func (c *Chain) Build(r io.Reader) bool {
-	br := bufio.NewReader(r)
-	p := make(Prefix, c.PrefixLen)
-	for {
-		var s string
-		if _, err := fmt.Fscan(br, &s); err != nil {
-			break
+// channelList splits the comma-separated -channel flag into the
+// individual channels the CONNECTED handler should join.
+func channelList() []string {
+	var chans []string
+	for _, ch := range strings.Split(*channel, ",") {
+		ch = strings.TrimSpace(ch)
+		if ch != "" {
+			chans = append(chans, ch)
 		}
-		s = strings.ToLower(s)
-		key := p.String()
-		mutex.Lock()
-		c.MapChain[key] = append(c.MapChain[key], s)
-		mutex.Unlock()
-		p.Shift(s)
 	}
-	return true
-}
-
-// Generate returns a string of at most n words generated from Chain.
-func (c *Chain) Generate(n int) string {
-	p := make(Prefix, c.PrefixLen)
-	var words []string
-	for i := 0; i < n; i++ {
-		mutex.Lock()
-		choices := c.MapChain[p.String()]
-		mutex.Unlock()
-		if len(choices) == 0 {
-			break
-		}
-		next := choices[rand.Intn(len(choices))]
-		words = append(words, next)
-		p.Shift(next)
-	}
-	return strings.Join(words, " ")
+	return chans
 }
 
 func ircConfig() *irc.Config {
@@ -118,83 +82,159 @@ func ircConfig() *irc.Config {
 	return cfg
 }
 
+// replyOrigin returns where a reply to line should be sent: the channel
+// it arrived on, or the sender's nick if it was a private message.
+func replyOrigin(line *irc.Line) string {
+	if len(line.Args) == 0 {
+		return line.Nick
+	}
+	if line.Args[0] == *myNick {
+		return line.Nick
+	}
+	return line.Args[0]
+}
+
 func handlePrivMsg(conn *irc.Conn, line *irc.Line) {
+	origin := replyOrigin(line)
+	trimmed := strings.TrimSpace(line.Text())
 	cleanText := ""
-	if strings.Contains(line.Text(), *myNick) {
+	switch {
+	case trimmed == "!brain" || strings.HasPrefix(trimmed, "!brain "):
+		handleBrainCommand(conn, origin, trimmed)
+	case trimmed == "!talk" || strings.HasPrefix(trimmed, "!talk "):
+		// !talk <word> primes the reply with a seed instead of
+		// generating from an empty prefix.
+		seed := strings.TrimSpace(strings.TrimPrefix(trimmed, "!talk"))
+		replyChannel <- replyMsg{Origin: origin, Seed: seed}
+		resp := <-replyChannel
+		sendSplit(conn, origin, resp.Text)
+		log.Println(resp.Text)
+	case strings.Contains(line.Text(), *myNick):
 		// Reply if the text contains my nickname
 		cleanText = strings.TrimPrefix(line.Text(), *myNick+": ")
 		cleanText = strings.TrimPrefix(cleanText, *myNick+":")
 		cleanText = strings.Replace(cleanText, *myNick, "", -1)
-		//c.Build(strings.NewReader(cleanText))
-		learnChannel <- cleanText
-		replyChannel <- ""     // Send an empty request
-		text := <-replyChannel // Get a reply back
-		conn.Privmsg(*channel, text)
-		log.Println(text)
-		//log.Println(c.MapChain)
-	} else {
+		learnChannel <- learnMsg{Origin: origin, Text: cleanText}
+		replyChannel <- replyMsg{Origin: origin} // Send an empty request (no seed)
+		resp := <-replyChannel                   // Get a reply back
+		sendSplit(conn, origin, resp.Text)
+		log.Println(resp.Text)
+	default:
 		// Else just learn from the input
-		//c.Build(strings.NewReader(cleanText))
-		learnChannel <- line.Text()
+		learnChannel <- learnMsg{Origin: origin, Text: line.Text()}
 	}
 }
 
-func learn() {
-	for {
-		text := <-learnChannel
-		log.Printf("Learned the following: %s\n", text)
-		_ = c.Build(strings.NewReader(text))
+// handleBrainCommand implements the operator-facing !brain subcommands:
+// list known partitions, borrow another channel's corpus, or reset the
+// calling channel's partition.
+func handleBrainCommand(conn *irc.Conn, origin, text string) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		conn.Privmsg(origin, "Usage: !brain list|borrow <channel>|reset")
+		return
+	}
+	switch fields[1] {
+	case "list":
+		conn.Privmsg(origin, "Partitions: "+strings.Join(brain.List(), ", "))
+	case "borrow":
+		if len(fields) < 3 {
+			conn.Privmsg(origin, "Usage: !brain borrow <channel>")
+			return
+		}
+		if brain.Borrow(*server, origin, fields[2]) {
+			conn.Privmsg(origin, "Borrowed the brain from "+fields[2])
+		} else {
+			conn.Privmsg(origin, "No brain recorded for "+fields[2])
+		}
+	case "reset":
+		brain.Reset(*server, origin)
+		conn.Privmsg(origin, "Brain reset for "+origin)
+	default:
+		conn.Privmsg(origin, "Usage: !brain list|borrow <channel>|reset")
 	}
 }
 
-func getReply() {
+func learn() {
 	for {
-		<-replyChannel
-		reply := c.Generate(*numWords)
-		log.Printf("Replying with: %s\n", reply)
-		replyChannel <- reply
+		msg := <-learnChannel
+		log.Printf("Learned the following in %s: %s\n", msg.Origin, msg.Text)
+		ch := brain.Get(*server, msg.Origin)
+		ch.BuildWithObserver(strings.NewReader(msg.Text), func(prefix, suffix string) {
+			if journal == nil {
+				return
+			}
+			if err := journal.Append(*server, msg.Origin, prefix, suffix); err != nil {
+				log.Printf("Could not journal %q -> %q: %s\n", prefix, suffix, err)
+			}
+		})
+		if journal != nil {
+			if err := journal.Flush(); err != nil {
+				log.Printf("Could not flush journal: %s\n", err)
+			}
+		}
 	}
 }
 
-func saveBrain(f *os.File) {
+func getReply() {
 	for {
-		time.Sleep(time.Second * 10)
-		log.Println("Saving brain...")
-		enc := gob.NewEncoder(f)
-		mutex.Lock()
-		err := enc.Encode(c)
-		mutex.Unlock()
-		if err != nil {
-			log.Printf("Could not save brain to disk: %s\n", err)
+		req := <-replyChannel
+		ch := brain.Get(*server, req.Origin)
+		var reply string
+		if req.Seed == "" {
+			reply = ch.Generate(*numWords)
+		} else {
+			reply = ch.GenerateFrom(req.Seed, *numWords)
 		}
+		log.Printf("Replying with: %s\n", reply)
+		replyChannel <- replyMsg{Origin: req.Origin, Text: reply}
 	}
 }
 
+const brainFile = "brain.gob"
+const journalFile = "brain.journal"
+
 func main() {
+	flag.Parse() // Parse command-line flags. Left out of init() so `go test` (which defines its own flags) doesn't choke on them.
+
 	quit := make(chan bool)
 
-	f, err := os.OpenFile("brain.gob", os.O_CREATE|os.O_RDWR, 0644)
+	f, err := os.OpenFile(brainFile, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		panic("Could not open file")
 	}
 
 	dec := gob.NewDecoder(f)
-
-	err = dec.Decode(&c)
+	err = dec.Decode(&brain)
 	if err != nil {
 		fmt.Printf("Could not load brain gob: %s\n", err)
-		c = NewChain(*prefixLen) // Initialize a new Chain.
+		brain = NewBrain() // Initialize an empty Brain.
 		fmt.Println("Generating new brain")
 	}
+	f.Close()
+
+	if err := replayJournal(journalFile, brain); err != nil {
+		log.Printf("Could not replay journal: %s\n", err)
+	}
+
+	journal, err = OpenJournal(journalFile)
+	if err != nil {
+		log.Fatalf("Could not open journal: %s", err)
+	}
 
 	// Start goroutines
 	go learn()
 	go getReply()
-	go saveBrain(f)
+	go compactLoop(brainFile, brain, journal)
+	if *httpAddr != "" {
+		go serveHTTP(*httpAddr)
+	}
 
 	client := irc.Client(ircConfig())
 	client.HandleFunc(irc.CONNECTED, func(conn *irc.Conn, line *irc.Line) {
-		conn.Join(*channel)
+		for _, ch := range channelList() {
+			conn.Join(ch)
+		}
 	})
 	client.HandleFunc(irc.DISCONNECTED, func(conn *irc.Conn, line *irc.Line) {
 		quit <- true
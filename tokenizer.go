@@ -0,0 +1,134 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer turns one whitespace-delimited input word into zero or more
+// chain tokens. Returning zero tokens drops the word entirely (e.g. a
+// URL); returning more than one splits off punctuation so "hello," and
+// "hello" are learned as the same word.
+type Tokenizer interface {
+	TokenizeWord(word string) []string
+}
+
+// Detokenizer reassembles a slice of generated tokens back into text.
+type Detokenizer interface {
+	Detokenize(tokens []string) string
+}
+
+// tokenizer and detokenizer are the pluggable hooks Build and Generate
+// use; defaultTokenizer/defaultDetokenizer below are the stock pair.
+var tokenizer Tokenizer = defaultTokenizer{}
+var detokenizer Detokenizer = defaultDetokenizer{}
+
+var urlPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://\S+$`)
+
+// sentenceEnders are the tokens generateFrom treats as the end of a
+// sentence when *stopAtSentence is set.
+var sentenceEnders = map[string]bool{".": true, "!": true, "?": true}
+
+// defaultTokenizer lowercases words (unicode-aware, via strings.ToLower),
+// drops URLs, IRC action markers (tokens like "*nick"), and control
+// characters, and splits leading/trailing punctuation into their own
+// tokens so sentence-ending punctuation becomes visible to the chain.
+type defaultTokenizer struct{}
+
+func (defaultTokenizer) TokenizeWord(word string) []string {
+	word = stripControl(word)
+	if word == "" {
+		return nil
+	}
+	if urlPattern.MatchString(word) {
+		return nil
+	}
+	if isActionMarker(word) {
+		return nil
+	}
+	return splitPunct(word)
+}
+
+// isActionMarker reports whether word looks like an IRC action/highlight
+// marker such as "*myNick" rather than conversational text.
+func isActionMarker(word string) bool {
+	return strings.HasPrefix(word, "*") && len(word) > 1
+}
+
+// stripControl removes unicode control characters (e.g. stray IRC
+// formatting codes) from word.
+func stripControl(word string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, word)
+}
+
+// splitPunct peels leading and trailing punctuation/symbol runes off
+// word into their own single-rune tokens, lowercasing the core word.
+func splitPunct(word string) []string {
+	runes := []rune(word)
+	start := 0
+	for start < len(runes) && isPunctRune(runes[start]) {
+		start++
+	}
+	end := len(runes)
+	for end > start && isPunctRune(runes[end-1]) {
+		end--
+	}
+
+	var tokens []string
+	for _, r := range runes[:start] {
+		tokens = append(tokens, string(r))
+	}
+	if start < end {
+		tokens = append(tokens, strings.ToLower(string(runes[start:end])))
+	}
+	for _, r := range runes[end:] {
+		tokens = append(tokens, string(r))
+	}
+	return tokens
+}
+
+func isPunctRune(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// openingPunct tokens attach to the word that follows them, not the one
+// before; noSpaceBefore tokens attach to the word before them instead of
+// starting a new, space-separated word.
+var openingPunct = map[string]bool{"(": true, "[": true, "{": true}
+var noSpaceBefore = map[string]bool{".": true, ",": true, "!": true, "?": true, ":": true, ";": true, ")": true, "]": true, "}": true}
+
+// quoteChars look identical whether they're opening or closing a quoted
+// phrase, unlike "(" vs ")", so Detokenize can't classify them with a
+// fixed table the way it does openingPunct/noSpaceBefore: it tracks each
+// one's own open/closed state instead and alternates.
+var quoteChars = map[string]bool{`"`: true, "'": true}
+
+// defaultDetokenizer reattaches punctuation tokens without a leading
+// space instead of strings.Join's uniform single-space gaps.
+type defaultDetokenizer struct{}
+
+func (defaultDetokenizer) Detokenize(tokens []string) string {
+	var b strings.Builder
+	openPending := false
+	quoteOpen := make(map[string]bool)
+	for _, t := range tokens {
+		isPunct := len([]rune(t)) == 1 && isPunctRune([]rune(t)[0])
+		opens, closes := openingPunct[t], noSpaceBefore[t]
+		if isPunct && quoteChars[t] {
+			opens, closes = !quoteOpen[t], quoteOpen[t]
+			quoteOpen[t] = !quoteOpen[t]
+		}
+		if b.Len() > 0 && !openPending && !(isPunct && closes) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t)
+		openPending = isPunct && opens
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestSplitPunct(t *testing.T) {
+	cases := []struct {
+		word string
+		want []string
+	}{
+		{"hello", []string{"hello"}},
+		{"hello,", []string{"hello", ","}},
+		{"\"hello", []string{"\"", "hello"}},
+		{"(hello)", []string{"(", "hello", ")"}},
+		{"HELLO", []string{"hello"}},
+		{"...", []string{".", ".", "."}},
+	}
+	for _, c := range cases {
+		got := splitPunct(c.word)
+		if len(got) != len(c.want) {
+			t.Errorf("splitPunct(%q) = %v, want %v", c.word, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("splitPunct(%q) = %v, want %v", c.word, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDetokenize(t *testing.T) {
+	cases := []struct {
+		name   string
+		tokens []string
+		want   string
+	}{
+		{"plain words", []string{"hello", "world"}, "hello world"},
+		{"trailing comma", []string{"hello", ",", "world"}, "hello, world"},
+		{"opening paren", []string{"say", "(", "hi", ")"}, "say (hi)"},
+		{
+			// Regression: a closing quote used to pick up a leading
+			// space because noSpaceBefore didn't know about quotes.
+			"quoted phrase",
+			[]string{"\"", "hello", "world", "\""},
+			"\"hello world\"",
+		},
+		{
+			// The opening quote must still get a leading space and no
+			// trailing one, which a naive "always noSpaceBefore" fix
+			// for the case above would have broken.
+			"word then quoted word",
+			[]string{"say", "\"", "hi", "\""},
+			"say \"hi\"",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := defaultDetokenizer{}.Detokenize(c.tokens)
+			if got != c.want {
+				t.Errorf("Detokenize(%v) = %q, want %q", c.tokens, got, c.want)
+			}
+		})
+	}
+}